@@ -0,0 +1,245 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyProtocolV1MaxLength is the maximum number of bytes, signature
+// through trailing CRLF inclusive, a PROXY protocol v1 header may occupy.
+const proxyProtocolV1MaxLength = 107
+
+// proxyProtocolV2Sig is the fixed 12 byte signature that starts every PROXY
+// protocol v2 header.
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener wraps a net.Listener, transparently parsing a
+// HAProxy PROXY protocol header (v1 text or v2 binary) off the start of
+// each accepted connection and exposing the original client address
+// through the returned conn's LocalAddr and RemoteAddr, instead of the
+// proxy's own.
+//
+// A connection whose header is malformed, too long, or declares an
+// unsupported family is closed without being handed to the caller; Accept
+// moves on to the next one rather than failing the listener.
+type ProxyProtocolListener struct {
+	net.Listener
+
+	// ReadHeaderTimeout bounds how long Accept waits for a complete PROXY
+	// protocol header before giving up on a connection. Zero means no
+	// timeout.
+	ReadHeaderTimeout time.Duration
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.ReadHeaderTimeout != 0 {
+			conn.SetReadDeadline(time.Now().Add(l.ReadHeaderTimeout))
+		}
+
+		pc, err := newProxyProtocolConn(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		if l.ReadHeaderTimeout != 0 {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		return pc, nil
+	}
+}
+
+// proxyProtocolConn is the net.Conn returned by ProxyProtocolListener; it
+// resumes reads after the PROXY header and reports the addresses the
+// header declared instead of the accepted socket's own.
+type proxyProtocolConn struct {
+	net.Conn
+	r          *bufio.Reader
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *proxyProtocolConn) LocalAddr() net.Addr        { return c.localAddr }
+func (c *proxyProtocolConn) RemoteAddr() net.Addr       { return c.remoteAddr }
+
+func newProxyProtocolConn(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReaderSize(conn, 256)
+
+	if head, err := r.Peek(len(proxyProtocolV2Sig)); err == nil && bytes.Equal(head, proxyProtocolV2Sig) {
+		return parseProxyProtocolV2(conn, r)
+	}
+
+	return parseProxyProtocolV1(conn, r)
+}
+
+func parseProxyProtocolV1(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	line, err := readProxyProtocolV1Line(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(line, "\r\n"))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("netx: malformed PROXY v1 header")
+	}
+
+	local, remote := conn.LocalAddr(), conn.RemoteAddr()
+
+	switch proto := fields[1]; proto {
+	case "UNKNOWN":
+		// A health check probing the proxy itself; keep the listener's own
+		// addresses.
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("netx: malformed PROXY v1 %s header", proto)
+		}
+
+		srcIP := net.ParseIP(fields[2])
+		dstIP := net.ParseIP(fields[3])
+		srcPort, srcErr := strconv.Atoi(fields[4])
+		dstPort, dstErr := strconv.Atoi(fields[5])
+
+		if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+			return nil, fmt.Errorf("netx: malformed PROXY v1 %s header", proto)
+		}
+
+		remote = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		local = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	default:
+		return nil, fmt.Errorf("netx: unsupported PROXY v1 protocol %q", proto)
+	}
+
+	return &proxyProtocolConn{Conn: conn, r: r, localAddr: local, remoteAddr: remote}, nil
+}
+
+// readProxyProtocolV1Line reads up to and including the header's trailing
+// LF, bailing out once proxyProtocolV1MaxLength bytes have been read
+// without finding one.
+func readProxyProtocolV1Line(r *bufio.Reader) (string, error) {
+	var buf bytes.Buffer
+
+	for buf.Len() < proxyProtocolV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("netx: malformed PROXY v1 header: %w", err)
+		}
+
+		buf.WriteByte(b)
+
+		if b == '\n' {
+			return buf.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("netx: PROXY v1 header exceeds %d bytes", proxyProtocolV1MaxLength)
+}
+
+const (
+	proxyProtocolV2CmdLocal = 0x0
+	proxyProtocolV2CmdProxy = 0x1
+
+	proxyProtocolV2FamUnspec = 0x0
+	proxyProtocolV2FamInet   = 0x1
+	proxyProtocolV2FamInet6  = 0x2
+	proxyProtocolV2FamUnix   = 0x3
+)
+
+func parseProxyProtocolV2(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("netx: malformed PROXY v2 header: %w", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("netx: unsupported PROXY v2 version %d", header[12]>>4)
+	}
+
+	cmd := header[12] & 0x0f
+	fam := header[13] >> 4
+	length := int(header[14])<<8 | int(header[15])
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("netx: malformed PROXY v2 header: %w", err)
+	}
+
+	local, remote := conn.LocalAddr(), conn.RemoteAddr()
+
+	switch cmd {
+	case proxyProtocolV2CmdLocal:
+		// The proxy is probing its own listener, e.g. a health check; keep
+		// the listener's own addresses.
+	case proxyProtocolV2CmdProxy:
+		switch fam {
+		case proxyProtocolV2FamUnspec:
+			// UNKNOWN transport; keep the listener's own addresses.
+		case proxyProtocolV2FamInet:
+			if len(body) < 12 {
+				return nil, fmt.Errorf("netx: truncated PROXY v2 INET address")
+			}
+			remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(body[8])<<8 | int(body[9])}
+			local = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(body[10])<<8 | int(body[11])}
+		case proxyProtocolV2FamInet6:
+			if len(body) < 36 {
+				return nil, fmt.Errorf("netx: truncated PROXY v2 INET6 address")
+			}
+			remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(body[32])<<8 | int(body[33])}
+			local = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(body[34])<<8 | int(body[35])}
+		case proxyProtocolV2FamUnix:
+			if len(body) < 216 {
+				return nil, fmt.Errorf("netx: truncated PROXY v2 UNIX address")
+			}
+			remote = &net.UnixAddr{Net: "unix", Name: nullTerminatedString(body[0:108])}
+			local = &net.UnixAddr{Net: "unix", Name: nullTerminatedString(body[108:216])}
+		default:
+			return nil, fmt.Errorf("netx: unsupported PROXY v2 address family %d", fam)
+		}
+	default:
+		return nil, fmt.Errorf("netx: unsupported PROXY v2 command %d", cmd)
+	}
+
+	return &proxyProtocolConn{Conn: conn, r: r, localAddr: local, remoteAddr: remote}, nil
+}
+
+func nullTerminatedString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// writeProxyHeaderV1 writes a PROXY protocol v1 header to w describing a
+// connection from remote to local, or "PROXY UNKNOWN\r\n" when either
+// address isn't a *net.TCPAddr.
+func writeProxyHeaderV1(w io.Writer, remote, local net.Addr) error {
+	rtcp, rok := remote.(*net.TCPAddr)
+	ltcp, lok := local.(*net.TCPAddr)
+
+	if !rok || !lok {
+		_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+		return err
+	}
+
+	proto := "TCP4"
+	if rtcp.IP.To4() == nil {
+		proto = "TCP6"
+	}
+
+	_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, rtcp.IP, ltcp.IP, rtcp.Port, ltcp.Port)
+	return err
+}
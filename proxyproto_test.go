@@ -0,0 +1,257 @@
+package netx
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyProtocolV1Line(t *testing.T) {
+	line := "PROXY TCP4 127.0.0.1 127.0.0.1 1 2\r\n"
+	r := bufio.NewReader(strings.NewReader(line + "trailing"))
+
+	got, err := readProxyProtocolV1Line(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != line {
+		t.Fatalf("line = %q, want %q", got, line)
+	}
+}
+
+func TestReadProxyProtocolV1LineEnforcesMaxLength(t *testing.T) {
+	// proxyProtocolV1MaxLength (107) bytes, ending in \n, must be accepted.
+	ok := strings.Repeat("a", proxyProtocolV1MaxLength-1) + "\n"
+	if _, err := readProxyProtocolV1Line(bufio.NewReader(strings.NewReader(ok))); err != nil {
+		t.Fatalf("a %d byte header should be accepted: %v", len(ok), err)
+	}
+
+	// One byte over the limit must be rejected, even though it still ends
+	// in a newline.
+	tooLong := strings.Repeat("a", proxyProtocolV1MaxLength) + "\n"
+	if _, err := readProxyProtocolV1Line(bufio.NewReader(strings.NewReader(tooLong))); err == nil {
+		t.Fatalf("a %d byte header should be rejected", len(tooLong))
+	}
+}
+
+func TestParseProxyProtocolV1(t *testing.T) {
+	from, to := net.Pipe()
+	defer from.Close()
+	defer to.Close()
+
+	tests := []struct {
+		name   string
+		header string
+		remote string
+		local  string
+	}{
+		{
+			name:   "tcp4",
+			header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+			remote: "192.168.0.1:56324",
+			local:  "192.168.0.11:443",
+		},
+		{
+			name:   "tcp6",
+			header: "PROXY TCP6 ::1 ::2 56324 443\r\n",
+			remote: "[::1]:56324",
+			local:  "[::2]:443",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(test.header))
+
+			conn, err := parseProxyProtocolV1(from, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conn.RemoteAddr().String(); got != test.remote {
+				t.Fatalf("RemoteAddr() = %q, want %q", got, test.remote)
+			}
+			if got := conn.LocalAddr().String(); got != test.local {
+				t.Fatalf("LocalAddr() = %q, want %q", got, test.local)
+			}
+		})
+	}
+}
+
+func TestParseProxyProtocolV1Unknown(t *testing.T) {
+	from, to := net.Pipe()
+	defer from.Close()
+	defer to.Close()
+
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n"))
+	conn, err := parseProxyProtocolV1(from, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// UNKNOWN keeps the underlying connection's own addresses.
+	if conn.RemoteAddr() != from.RemoteAddr() {
+		t.Fatalf("RemoteAddr() = %v, want %v", conn.RemoteAddr(), from.RemoteAddr())
+	}
+}
+
+func TestParseProxyProtocolV1Malformed(t *testing.T) {
+	from, to := net.Pipe()
+	defer from.Close()
+	defer to.Close()
+
+	tests := []string{
+		"NOTPROXY TCP4 1 2 3 4\r\n",
+		"PROXY TCP4 notanip 192.168.0.1 1 2\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.1 notaport 2\r\n",
+		"PROXY SCTP 1 2 3 4\r\n",
+	}
+
+	for _, header := range tests {
+		r := bufio.NewReader(strings.NewReader(header))
+		if _, err := parseProxyProtocolV1(from, r); err == nil {
+			t.Errorf("parseProxyProtocolV1(%q): expected an error", header)
+		}
+	}
+}
+
+func proxyProtocolV2Header(cmd, fam byte, body []byte) []byte {
+	header := make([]byte, 16)
+	copy(header, proxyProtocolV2Sig)
+	header[12] = 0x20 | cmd
+	header[13] = fam << 4
+	header[14] = byte(len(body) >> 8)
+	header[15] = byte(len(body))
+	return append(header, body...)
+}
+
+func TestParseProxyProtocolV2(t *testing.T) {
+	from, to := net.Pipe()
+	defer from.Close()
+	defer to.Close()
+
+	t.Run("inet", func(t *testing.T) {
+		body := []byte{
+			192, 168, 0, 1, // src addr
+			192, 168, 0, 11, // dst addr
+			0xdc, 0x04, // src port 56324
+			0x01, 0xbb, // dst port 443
+		}
+		raw := proxyProtocolV2Header(proxyProtocolV2CmdProxy, proxyProtocolV2FamInet, body)
+		r := bufio.NewReader(strings.NewReader(string(raw)))
+
+		conn, err := parseProxyProtocolV2(from, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != "192.168.0.1:56324" {
+			t.Fatalf("RemoteAddr() = %q, want %q", got, "192.168.0.1:56324")
+		}
+		if got := conn.LocalAddr().String(); got != "192.168.0.11:443" {
+			t.Fatalf("LocalAddr() = %q, want %q", got, "192.168.0.11:443")
+		}
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		body := make([]byte, 216)
+		copy(body[0:], "/tmp/src.sock")
+		copy(body[108:], "/tmp/dst.sock")
+		raw := proxyProtocolV2Header(proxyProtocolV2CmdProxy, proxyProtocolV2FamUnix, body)
+		r := bufio.NewReader(strings.NewReader(string(raw)))
+
+		conn, err := parseProxyProtocolV2(from, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != "/tmp/src.sock" {
+			t.Fatalf("RemoteAddr() = %q, want %q", got, "/tmp/src.sock")
+		}
+		if got := conn.LocalAddr().String(); got != "/tmp/dst.sock" {
+			t.Fatalf("LocalAddr() = %q, want %q", got, "/tmp/dst.sock")
+		}
+	})
+
+	t.Run("local keeps listener addresses", func(t *testing.T) {
+		raw := proxyProtocolV2Header(proxyProtocolV2CmdLocal, proxyProtocolV2FamUnspec, nil)
+		r := bufio.NewReader(strings.NewReader(string(raw)))
+
+		conn, err := parseProxyProtocolV2(from, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr() != from.RemoteAddr() {
+			t.Fatalf("RemoteAddr() = %v, want %v", conn.RemoteAddr(), from.RemoteAddr())
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		raw := proxyProtocolV2Header(proxyProtocolV2CmdProxy, proxyProtocolV2FamInet, make([]byte, 12))
+		raw[12] = 0x10 // version 1
+		r := bufio.NewReader(strings.NewReader(string(raw)))
+
+		if _, err := parseProxyProtocolV2(from, r); err == nil {
+			t.Fatal("expected an error for an unsupported version")
+		}
+	})
+}
+
+func TestNewProxyProtocolConnDispatchesOnSignature(t *testing.T) {
+	from, to := net.Pipe()
+	defer to.Close()
+
+	go func() {
+		raw := proxyProtocolV2Header(proxyProtocolV2CmdProxy, proxyProtocolV2FamInet, []byte{
+			10, 0, 0, 1, 10, 0, 0, 2, 0, 80, 0, 81,
+		})
+		to.Write(raw)
+		to.Write([]byte("payload"))
+	}()
+
+	conn, err := newProxyProtocolConn(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().String(); got != "10.0.0.1:80" {
+		t.Fatalf("RemoteAddr() = %q, want %q", got, "10.0.0.1:80")
+	}
+
+	buf := make([]byte, len("payload"))
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "payload" {
+		t.Fatalf("Read() = %q, want %q", buf, "payload")
+	}
+}
+
+func TestWriteProxyHeaderV1(t *testing.T) {
+	var buf strings.Builder
+
+	remote := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 56324}
+	local := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 11), Port: 443}
+
+	if err := writeProxyHeaderV1(&buf, remote, local); err != nil {
+		t.Fatal(err)
+	}
+	want := "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+	if buf.String() != want {
+		t.Fatalf("writeProxyHeaderV1() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteProxyHeaderV1Unknown(t *testing.T) {
+	var buf strings.Builder
+
+	from, to := net.Pipe()
+	defer from.Close()
+	defer to.Close()
+
+	if err := writeProxyHeaderV1(&buf, from.RemoteAddr(), from.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "PROXY UNKNOWN\r\n" {
+		t.Fatalf("writeProxyHeaderV1() = %q, want %q", buf.String(), "PROXY UNKNOWN\r\n")
+	}
+}
@@ -3,6 +3,7 @@ package netx
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"time"
@@ -38,6 +39,12 @@ type Tunnel struct {
 	// DialContext can be set to a dialing function to configure how the tunnel
 	// establishes new connections.
 	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// SendProxyHeader, when set, makes the tunnel prepend a PROXY protocol
+	// v1 header carrying from's addresses to every connection it dials, so
+	// a Tunnel running as an L4 proxy can preserve the original client's
+	// identity to upstreams that understand the protocol.
+	SendProxyHeader bool
 }
 
 // ServeProxy satisfies the ProxyHandler interface.
@@ -50,7 +57,11 @@ func (t *Tunnel) ServeProxy(ctx context.Context, from net.Conn, target net.Addr)
 	dial := t.DialContext
 
 	if dial == nil {
-		dial = (&net.Dialer{Timeout: 10 * time.Second /* safeguard */}).DialContext
+		dial = dialNetwork
+	}
+
+	if t.SendProxyHeader {
+		dial = sendProxyHeader(dial, from)
 	}
 
 	to, err := dial(ctx, target.Network(), target.String())
@@ -62,6 +73,65 @@ func (t *Tunnel) ServeProxy(ctx context.Context, from net.Conn, target net.Addr)
 	t.Handler.ServeTunnel(ctx, from, to)
 }
 
+// TunnelTargetHandler is implemented by tunnel handlers that need to inspect
+// a connection's initial bytes before the tunnel knows what address to
+// dial, e.g. TunnelTLS reading the SNI server name out of a ClientHello, or
+// TunnelHTTP reading the Host header out of a request line.
+type TunnelTargetHandler interface {
+	TunnelHandler
+
+	// Target peeks at from to determine the address the tunnel should dial.
+	// It returns a replacement for from that still exposes any bytes it
+	// consumed to subsequent reads.
+	Target(ctx context.Context, from net.Conn) (net.Conn, net.Addr, error)
+}
+
+// ServeProxyPeek is like ServeProxy, but for handlers that don't know the
+// dial target until they've peeked at the connection, such as TunnelTLS and
+// TunnelHTTP. t.Handler must implement TunnelTargetHandler.
+//
+// The method panics to report errors.
+func (t *Tunnel) ServeProxyPeek(ctx context.Context, from net.Conn) {
+	h, ok := t.Handler.(TunnelTargetHandler)
+	if !ok {
+		panic(fmt.Errorf("netx: %T does not implement TunnelTargetHandler", t.Handler))
+	}
+
+	from, target, err := h.Target(ctx, from)
+	if err != nil {
+		panic(err)
+	}
+
+	t.ServeProxy(ctx, from, target)
+}
+
+// dialNetwork is the default dialer used by Tunnel.ServeProxy when
+// DialContext is nil. It consults the networks registry so pseudo-networks
+// like "unixfd" are dispatched the same way a Tunnel would dial "tcp" or
+// "unix", falling back to net.Dialer otherwise.
+func dialNetwork(ctx context.Context, network, address string) (net.Conn, error) {
+	if resolver, ok := networks[network]; ok {
+		return resolver.DialNetwork(ctx, address)
+	}
+	return (&net.Dialer{Timeout: 10 * time.Second /* safeguard */}).DialContext(ctx, network, address)
+}
+
+// sendProxyHeader wraps dial so that every connection it establishes starts
+// with a PROXY protocol v1 header carrying from's addresses.
+func sendProxyHeader(dial func(context.Context, string, string) (net.Conn, error), from net.Conn) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		to, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeProxyHeaderV1(to, from.RemoteAddr(), from.LocalAddr()); err != nil {
+			to.Close()
+			return nil, err
+		}
+		return to, nil
+	}
+}
+
 var (
 	// TunnelRaw is the implementation of a tunnel handler which passes bytes
 	// back and forth between the two ends of a tunnel.
@@ -86,9 +156,11 @@ var (
 func tunnelRaw(ctx context.Context, from net.Conn, to net.Conn) {
 	ctx, cancel := context.WithCancel(ctx)
 
-	copy := func(w io.Writer, r io.Reader) {
+	copy := func(w net.Conn, r net.Conn) {
 		defer cancel()
-		Copy(w, r)
+		if !trySplice(ctx, w, r) {
+			Copy(w, r)
+		}
 	}
 
 	go copy(to, from)
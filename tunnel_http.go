@@ -0,0 +1,51 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+)
+
+// TunnelHTTP returns a TunnelHandler that reads the HTTP/1.1 request line
+// and headers off the incoming connection and calls target with the Host
+// header to resolve the tunnel's destination before dialing, then forwards
+// bytes in both directions once connected, preserving half-close so
+// keep-alive connections aren't truncated.
+//
+// Because the target isn't known until the request line and headers have
+// been read, use it with Tunnel.ServeProxyPeek rather than Tunnel.ServeProxy.
+func TunnelHTTP(target TargetFunc) TunnelHandler {
+	return &tunnelHTTP{target: target}
+}
+
+type tunnelHTTP struct {
+	target TargetFunc
+}
+
+func (h *tunnelHTTP) Target(ctx context.Context, from net.Conn) (net.Conn, net.Addr, error) {
+	var raw bytes.Buffer
+	r := bufio.NewReader(io.TeeReader(from, &raw))
+
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr, err := h.target(req.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// raw holds every byte ReadRequest pulled off from, including whatever
+	// the bufio.Reader buffered ahead of the headers (the start of the
+	// body, or a pipelined request); replay it before resuming reads
+	// straight from from.
+	return newPeekedConn(from, io.MultiReader(bytes.NewReader(raw.Bytes()), from)), addr, nil
+}
+
+func (h *tunnelHTTP) ServeTunnel(ctx context.Context, from net.Conn, to net.Conn) {
+	tunnelHalfClose(ctx, from, to)
+}
@@ -0,0 +1,69 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestTunnelHTTPTarget(t *testing.T) {
+	from, client := net.Pipe()
+	defer client.Close()
+
+	const request = "GET /widgets HTTP/1.1\r\nHost: widgets.example.com\r\n\r\n"
+	go func() {
+		io.WriteString(client, request)
+	}()
+
+	var gotHost string
+	h := &tunnelHTTP{target: func(host string) (net.Addr, error) {
+		gotHost = host
+		return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}, nil
+	}}
+
+	conn, addr, err := h.Target(context.Background(), from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if gotHost != "widgets.example.com" {
+		t.Fatalf("target host = %q, want %q", gotHost, "widgets.example.com")
+	}
+	if addr.String() != "10.0.0.1:80" {
+		t.Fatalf("target addr = %q, want %q", addr.String(), "10.0.0.1:80")
+	}
+
+	// The peeked connection must still expose the consumed request bytes,
+	// followed by whatever the client writes afterwards.
+	go func() {
+		io.WriteString(client, "body")
+	}()
+
+	buf := make([]byte, len(request)+len("body"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != request+"body" {
+		t.Fatalf("replayed bytes = %q, want %q", buf, request+"body")
+	}
+}
+
+func TestTunnelHTTPTargetMalformedRequest(t *testing.T) {
+	from, client := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		io.WriteString(client, "not an http request\r\n\r\n")
+	}()
+
+	h := &tunnelHTTP{target: func(string) (net.Addr, error) {
+		t.Fatal("target should not be called for a malformed request")
+		return nil, nil
+	}}
+
+	if _, _, err := h.Target(context.Background(), from); err == nil {
+		t.Fatal("expected an error for a malformed request")
+	}
+}
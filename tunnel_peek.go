@@ -0,0 +1,89 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// TargetFunc resolves the address a tunnel should dial from a value peeked
+// off the start of a connection, e.g. a TLS SNI server name or an HTTP Host
+// header.
+type TargetFunc func(name string) (net.Addr, error)
+
+// peekedConn is a net.Conn that resumes reads from r instead of the wrapped
+// connection directly, so that bytes already consumed while peeking at a
+// connection (a ClientHello, an HTTP request line and headers) are replayed
+// to whatever reads from it next.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newPeekedConn(conn net.Conn, r io.Reader) net.Conn {
+	return &peekedConn{Conn: conn, r: r}
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite half-closes the wrapped connection when it supports it, or
+// closes it outright otherwise, so wrapping doesn't hide half-close support
+// from closeWrite.
+func (c *peekedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// closeWriter is implemented by connections that support half-close, such
+// as *net.TCPConn and *net.UnixConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes conn's write side when it supports it, or closes
+// it outright otherwise.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(closeWriter); ok {
+		cw.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// tunnelHalfClose copies bytes in both directions between from and to,
+// propagating EOF on one direction as a CloseWrite on the other instead of
+// tearing down both connections as soon as either direction reaches EOF.
+// This is what lets keep-alive HTTP and long-lived TLS sessions survive one
+// side half-closing its write end, unlike tunnelRaw.
+func tunnelHalfClose(ctx context.Context, from, to net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	relay := func(w net.Conn, r net.Conn) {
+		defer wg.Done()
+		Copy(w, r)
+		closeWrite(w)
+	}
+
+	go relay(to, from)
+	go relay(from, to)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+
+	from.Close()
+	to.Close()
+}
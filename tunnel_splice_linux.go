@@ -0,0 +1,202 @@
+//go:build linux
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// spliceFMove and spliceFNonblock mirror the SPLICE_F_MOVE and
+// SPLICE_F_NONBLOCK flags from <linux/splice.h>; the syscall package
+// doesn't export them.
+const (
+	spliceFMove     = 0x1
+	spliceFNonblock = 0x2
+)
+
+// spliceChunkSize bounds how many bytes a single splice(2) call is asked to
+// move, matching the default Linux pipe buffer size.
+const spliceChunkSize = 1 << 16
+
+// errSpliceCancelled is returned internally by waitFDReady and splice
+// when ctx is done while blocked waiting for a descriptor; it never escapes
+// trySplice.
+var errSpliceCancelled = errors.New("netx: splice cancelled")
+
+// trySplice copies bytes from r to w with splice(2) through a pipe instead
+// of the userspace Copy(w, r) loop, and reports whether it could handle the
+// direction at all. It returns false, having moved no bytes, when either
+// conn doesn't expose a file descriptor (e.g. a TLS conn) or the pipe
+// required to stage the splice can't be created, leaving the caller to fall
+// back to Copy.
+//
+// Because splice blocks on a dup of r's and w's descriptors rather than on r
+// or w themselves, closing r or w doesn't interrupt it; trySplice instead
+// watches ctx itself and, when it's done, writes a byte to its own cancel
+// pipe to wake the blocked epoll_wait and unwind the splice loop.
+func trySplice(ctx context.Context, w, r net.Conn) bool {
+	rf, ok := fileOf(r)
+	if !ok {
+		return false
+	}
+	defer rf.Close()
+
+	wf, ok := fileOf(w)
+	if !ok {
+		return false
+	}
+	defer wf.Close()
+
+	pr, pw, err := newSplicePipe()
+	if err != nil {
+		return false
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	cr, cw, err := newSplicePipe()
+	if err != nil {
+		return false
+	}
+	defer cr.Close()
+	defer cw.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cw.Write([]byte{0})
+		case <-done:
+		}
+	}()
+
+	// Fd switches the duplicated descriptors, and with them the open file
+	// descriptions they share with r and w, into blocking mode; switch them
+	// back so r and w keep working with Go's poller.
+	rfd, wfd := int(rf.Fd()), int(wf.Fd())
+	syscall.SetNonblock(rfd, true)
+	syscall.SetNonblock(wfd, true)
+
+	spliceLoop(rfd, wfd, int(pr.Fd()), int(pw.Fd()), int(cr.Fd()))
+	closeWrite(w)
+	return true
+}
+
+// fileOf returns the *os.File backing conn, for conns that support it.
+//
+// The returned file is a dup of conn's descriptor: closing it once the
+// splice loop is done doesn't affect conn, and since splice operates
+// directly on the duplicated descriptor there's no need to read from or
+// write to conn (and put it back into blocking mode) ever again on this
+// path.
+func fileOf(conn net.Conn) (*os.File, bool) {
+	fc, ok := conn.(fileConn)
+	if !ok {
+		return nil, false
+	}
+	f, err := fc.File()
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+func newSplicePipe() (r, w *os.File, err error) {
+	var fds [2]int
+	if err = syscall.Pipe2(fds[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return nil, nil, os.NewSyscallError("pipe2", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "pipe-r"), os.NewFile(uintptr(fds[1]), "pipe-w"), nil
+}
+
+// spliceLoop moves bytes from rfd to wfd through the pipe (prfd, pwfd)
+// until it hits EOF, an unrecoverable error, or cancelFD becomes readable.
+func spliceLoop(rfd, wfd, prfd, pwfd, cancelFD int) {
+	for {
+		n, err := splice(rfd, pwfd, spliceChunkSize, rfd, cancelFD, false)
+		if n == 0 || err != nil {
+			return
+		}
+
+		for n > 0 {
+			m, err := splice(prfd, wfd, int(n), wfd, cancelFD, true)
+			if err != nil {
+				return
+			}
+			n -= m
+		}
+	}
+}
+
+// splice wraps syscall.Splice, retrying on EINTR and, since the duplicated
+// descriptors inherit the non-blocking mode Go puts every net.Conn's fd in,
+// waiting for waitFD to become ready on EAGAIN instead of treating it as
+// EOF or a fatal error. The wait is abandoned, returning errSpliceCancelled,
+// if cancelFD becomes readable first.
+func splice(rfd, wfd int, n int, waitFD int, cancelFD int, waitWrite bool) (int64, error) {
+	for {
+		sn, err := syscall.Splice(rfd, nil, wfd, nil, n, spliceFMove|spliceFNonblock)
+		switch err {
+		case nil:
+			return sn, nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			if werr := waitFDReady(waitFD, cancelFD, waitWrite); werr != nil {
+				return 0, werr
+			}
+		default:
+			return 0, err
+		}
+	}
+}
+
+// waitFDReady blocks until fd is ready for reading or writing, or cancelFD
+// becomes readable, using epoll rather than select(2) since a proxy
+// handling more than ~140 concurrent splices (each holding 7 descriptors:
+// two dups, two pipes and an epoll instance) will routinely see fds past
+// select's 1024-fd/FD_SETSIZE limit.
+func waitFDReady(fd, cancelFD int, write bool) error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return os.NewSyscallError("epoll_create1", err)
+	}
+	defer syscall.Close(epfd)
+
+	events := uint32(syscall.EPOLLIN)
+	if write {
+		events = syscall.EPOLLOUT
+	}
+
+	event := syscall.EpollEvent{Fd: int32(fd), Events: events}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		return os.NewSyscallError("epoll_ctl", err)
+	}
+
+	cancelEvent := syscall.EpollEvent{Fd: int32(cancelFD), Events: syscall.EPOLLIN}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, cancelFD, &cancelEvent); err != nil {
+		return os.NewSyscallError("epoll_ctl", err)
+	}
+
+	var out [2]syscall.EpollEvent
+	for {
+		n, err := syscall.EpollWait(epfd, out[:], -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("epoll_wait", err)
+		}
+		for _, ev := range out[:n] {
+			if int(ev.Fd) == cancelFD {
+				return errSpliceCancelled
+			}
+		}
+		return nil
+	}
+}
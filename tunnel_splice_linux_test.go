@@ -0,0 +1,198 @@
+//go:build linux
+
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitFDReadyRead(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	cr, cw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	defer cw.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitFDReady(int(pr.Fd()), int(cr.Fd()), false)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("waitFDReady returned before the pipe had data: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := pw.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitFDReady did not unblock once the pipe became readable")
+	}
+}
+
+func TestWaitFDReadyWrite(t *testing.T) {
+	_, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pw.Close()
+
+	cr, cw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	defer cw.Close()
+
+	// A pipe's write end is ready the instant it's created.
+	if err := waitFDReady(int(pw.Fd()), int(cr.Fd()), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitFDReadyCancel(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	cr, cw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	defer cw.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitFDReady(int(pr.Fd()), int(cr.Fd()), false)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("waitFDReady returned before the cancel fd was signalled: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := cw.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != errSpliceCancelled {
+			t.Fatalf("waitFDReady() = %v, want %v", err, errSpliceCancelled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitFDReady did not unblock once the cancel fd became readable")
+	}
+}
+
+func TestTrySplice(t *testing.T) {
+	rSrc, rDst := unixSocketpair(t)
+	defer rSrc.Close()
+	defer rDst.Close()
+
+	wSrc, wDst := unixSocketpair(t)
+	defer wSrc.Close()
+	defer wDst.Close()
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- trySplice(context.Background(), wSrc, rDst)
+	}()
+
+	msg := []byte("splice me")
+	if _, err := rSrc.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	rSrc.Close()
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(wDst, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(msg) {
+		t.Fatalf("forwarded %q, want %q", buf, msg)
+	}
+
+	if ok := <-done; !ok {
+		t.Fatal("trySplice reported it couldn't handle two unix conns")
+	}
+}
+
+func TestTrySpliceUnsupportedConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c, d := net.Pipe()
+	defer c.Close()
+	defer d.Close()
+
+	if trySplice(context.Background(), a, c) {
+		t.Fatal("trySplice should report false for conns without a backing file descriptor")
+	}
+}
+
+// TestTrySpliceContextCancel verifies that cancelling ctx unblocks a splice
+// that's waiting on a peer which is still open but idle, rather than
+// requiring the peer to close or send data. A buggy implementation that
+// relies on closing the caller's net.Conn to interrupt the blocked
+// epoll_wait would hang here until the test's own deadline killed it.
+func TestTrySpliceContextCancel(t *testing.T) {
+	rSrc, rDst := unixSocketpair(t)
+	defer rSrc.Close()
+	defer rDst.Close()
+
+	wSrc, wDst := unixSocketpair(t)
+	defer wSrc.Close()
+	defer wDst.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- trySplice(ctx, wSrc, rDst)
+	}()
+
+	select {
+	case ok := <-done:
+		t.Fatalf("trySplice returned (%v) before the context was even cancelled", ok)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("trySplice reported it couldn't handle two unix conns")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("trySplice did not unblock after context cancellation")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package netx
+
+import (
+	"context"
+	"net"
+)
+
+// trySplice always reports that it couldn't handle the copy, so tunnelRaw
+// falls back to its userspace Copy(w, r) loop on platforms without
+// splice(2).
+func trySplice(ctx context.Context, w, r net.Conn) bool {
+	return false
+}
@@ -0,0 +1,127 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+)
+
+// TunnelTLS returns a TunnelHandler that peeks the SNI server name out of
+// the ClientHello on the incoming connection and calls target to resolve
+// the tunnel's destination before dialing, then forwards bytes in both
+// directions once connected, preserving half-close.
+//
+// Because the target isn't known until the ClientHello has been read, use
+// it with Tunnel.ServeProxyPeek rather than Tunnel.ServeProxy.
+func TunnelTLS(target TargetFunc) TunnelHandler {
+	return &tunnelTLS{target: target}
+}
+
+type tunnelTLS struct {
+	target TargetFunc
+}
+
+func (h *tunnelTLS) Target(ctx context.Context, from net.Conn) (net.Conn, net.Addr, error) {
+	r := bufio.NewReader(from)
+
+	sni, err := peekClientHelloServerName(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr, err := h.target(sni)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newPeekedConn(from, r), addr, nil
+}
+
+func (h *tunnelTLS) ServeTunnel(ctx context.Context, from net.Conn, to net.Conn) {
+	tunnelHalfClose(ctx, from, to)
+}
+
+// peekClientHelloServerName reads a single TLS record containing a
+// ClientHello off r and extracts the server_name extension (SNI), without
+// consuming anything beyond that one handshake message.
+func peekClientHelloServerName(r *bufio.Reader) (string, error) {
+	head, err := r.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	if head[0] != 0x16 {
+		return "", fmt.Errorf("netx: not a TLS handshake record")
+	}
+
+	record := make([]byte, 5+(int(head[3])<<8|int(head[4])))
+	if _, err := io.ReadFull(r, record); err != nil {
+		return "", err
+	}
+	body := record[5:]
+
+	if len(body) < 4 || body[0] != 0x01 {
+		return "", fmt.Errorf("netx: not a TLS client hello")
+	}
+
+	msg := body[4:]
+	pos := 34 // client_version(2) + random(32)
+	if pos >= len(msg) {
+		return "", fmt.Errorf("netx: truncated client hello")
+	}
+
+	pos += 1 + int(msg[pos]) // session_id
+	if pos+2 > len(msg) {
+		return "", fmt.Errorf("netx: truncated client hello")
+	}
+
+	pos += 2 + (int(msg[pos])<<8 | int(msg[pos+1])) // cipher_suites
+	if pos+1 > len(msg) {
+		return "", fmt.Errorf("netx: truncated client hello")
+	}
+
+	pos += 1 + int(msg[pos]) // compression_methods
+	if pos+2 > len(msg) {
+		return "", fmt.Errorf("netx: client hello has no extensions")
+	}
+
+	extensionsLen := int(msg[pos])<<8 | int(msg[pos+1])
+	pos += 2
+	if pos+extensionsLen > len(msg) {
+		return "", fmt.Errorf("netx: truncated client hello extensions")
+	}
+	extensions := msg[pos : pos+extensionsLen]
+
+	for len(extensions) >= 4 {
+		extType := int(extensions[0])<<8 | int(extensions[1])
+		extLen := int(extensions[2])<<8 | int(extensions[3])
+		extensions = extensions[4:]
+
+		if len(extensions) < extLen {
+			return "", fmt.Errorf("netx: truncated client hello extension")
+		}
+		extData := extensions[:extLen]
+		extensions = extensions[extLen:]
+
+		if extType != 0 /* server_name */ || len(extData) < 2 {
+			continue
+		}
+
+		for names := extData[2:]; len(names) >= 3; {
+			nameType := names[0]
+			nameLen := int(names[1])<<8 | int(names[2])
+			names = names[3:]
+
+			if len(names) < nameLen {
+				break
+			}
+			if nameType == 0 /* host_name */ {
+				return string(names[:nameLen]), nil
+			}
+			names = names[nameLen:]
+		}
+	}
+
+	return "", fmt.Errorf("netx: client hello has no SNI server name")
+}
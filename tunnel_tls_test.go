@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// buildClientHelloRecord builds a single TLS handshake record containing a
+// minimal ClientHello that advertises sni via the server_name extension.
+func buildClientHelloRecord(sni string) []byte {
+	var serverName bytes.Buffer
+	serverName.WriteByte(0) // name_type: host_name
+	serverName.WriteByte(byte(len(sni) >> 8))
+	serverName.WriteByte(byte(len(sni)))
+	serverName.WriteString(sni)
+
+	var serverNameList bytes.Buffer
+	serverNameList.WriteByte(byte(serverName.Len() >> 8))
+	serverNameList.WriteByte(byte(serverName.Len()))
+	serverNameList.Write(serverName.Bytes())
+
+	var ext bytes.Buffer
+	ext.WriteByte(0) // extension type: server_name
+	ext.WriteByte(0)
+	ext.WriteByte(byte(serverNameList.Len() >> 8))
+	ext.WriteByte(byte(serverNameList.Len()))
+	ext.Write(serverNameList.Bytes())
+
+	var msg bytes.Buffer
+	msg.WriteByte(0x03) // client_version
+	msg.WriteByte(0x03)
+	msg.Write(make([]byte, 32)) // random
+	msg.WriteByte(0)            // session_id: empty
+	msg.WriteByte(0)            // cipher_suites length
+	msg.WriteByte(2)
+	msg.Write([]byte{0x00, 0x2f}) // one cipher suite
+	msg.WriteByte(1)              // compression_methods length
+	msg.WriteByte(0)              // null compression
+	msg.WriteByte(byte(ext.Len() >> 8))
+	msg.WriteByte(byte(ext.Len()))
+	msg.Write(ext.Bytes())
+
+	var body bytes.Buffer
+	body.WriteByte(0x01) // handshake type: client_hello
+	body.WriteByte(byte(msg.Len() >> 16))
+	body.WriteByte(byte(msg.Len() >> 8))
+	body.WriteByte(byte(msg.Len()))
+	body.Write(msg.Bytes())
+
+	var record bytes.Buffer
+	record.WriteByte(0x16) // content type: handshake
+	record.WriteByte(0x03)
+	record.WriteByte(0x01)
+	record.WriteByte(byte(body.Len() >> 8))
+	record.WriteByte(byte(body.Len()))
+	record.Write(body.Bytes())
+
+	return record.Bytes()
+}
+
+func TestPeekClientHelloServerName(t *testing.T) {
+	record := buildClientHelloRecord("example.com")
+	r := bufio.NewReader(bytes.NewReader(record))
+
+	sni, err := peekClientHelloServerName(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sni != "example.com" {
+		t.Fatalf("sni = %q, want %q", sni, "example.com")
+	}
+}
+
+func TestPeekClientHelloServerNameNotHandshake(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte{0x17, 0x03, 0x01, 0x00, 0x01, 0x00}))
+
+	if _, err := peekClientHelloServerName(r); err == nil {
+		t.Fatal("expected an error for a non-handshake record")
+	}
+}
+
+func TestPeekClientHelloServerNameTruncated(t *testing.T) {
+	record := buildClientHelloRecord("example.com")
+	r := bufio.NewReader(bytes.NewReader(record[:len(record)-10]))
+
+	if _, err := peekClientHelloServerName(r); err == nil {
+		t.Fatal("expected an error for a truncated client hello")
+	}
+}
@@ -1,7 +1,9 @@
 package netx
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"syscall"
@@ -13,6 +15,21 @@ type fileConn interface {
 	File() (*os.File, error)
 }
 
+const (
+	// unixMsgHasFDs is set on the high bit of a unixMsg frame header when the
+	// message carries file descriptors alongside its payload.
+	unixMsgHasFDs = 1 << 31
+
+	// unixMsgMaxLength is the largest payload a single unixMsg frame can
+	// carry, the remaining 31 bits of the frame header.
+	unixMsgMaxLength = unixMsgHasFDs - 1
+
+	// unixMsgMaxFDs bounds how many file descriptors RecvUnixMsg will parse
+	// out of a single control message, so a peer can't force unbounded
+	// allocation by stuffing a message with rights.
+	unixMsgMaxFDs = 16
+)
+
 // SendUnixConn sends a file descriptor embedded in conn over the unix domain
 // socket.
 // On success conn is closed because the owner is now the process that received
@@ -21,81 +38,257 @@ type fileConn interface {
 // conn must be a *net.TCPConn or similar (providing a File method) or the
 // function will panic.
 func SendUnixConn(socket *net.UnixConn, conn net.Conn) (err error) {
-	var c = conn.(fileConn)
-	var f *os.File
+	return SendUnixMsg(socket, nil, conn)
+}
 
-	if f, err = c.File(); err != nil {
+// SendUnixFile sends a file descriptor embedded in file over the unix domain
+// socket.
+// On success the file is closed because the owner is now the process that
+// received the file descriptor.
+func SendUnixFile(socket *net.UnixConn, file *os.File) (err error) {
+	if err = sendUnixMsg(socket, nil, []*os.File{file}); err != nil {
 		return
 	}
-	defer f.Close()
+	file.Close()
+	return
+}
+
+// SendUnixMsg sends data to socket along with the file descriptors embedded
+// in conns, as a single sendmsg so the payload and the descriptors arrive
+// atomically on the other end.
+//
+// Each message is framed with a 4 byte big-endian header: the high bit is
+// set when the message carries file descriptors, and the low 31 bits hold
+// the length of data. Framing the message this way lets RecvUnixMsg split
+// coalesced reads back into the original (data, fds) tuples.
+//
+// On success conns are closed because the owner is now the process that
+// received the file descriptors.
+//
+// Each value in conns must be a *net.TCPConn or similar (providing a File
+// method) or the function returns an error.
+func SendUnixMsg(socket *net.UnixConn, data []byte, conns ...net.Conn) (err error) {
+	files := make([]*os.File, len(conns))
 
-	if err = SendUnixFile(socket, f); err != nil {
+	for i, conn := range conns {
+		fc, ok := conn.(fileConn)
+		if !ok {
+			closeFiles(files[:i])
+			return fmt.Errorf("netx: %T cannot be sent as a file descriptor", conn)
+		}
+		if files[i], err = fc.File(); err != nil {
+			closeFiles(files[:i])
+			return
+		}
+	}
+	defer closeFiles(files)
+
+	if err = sendUnixMsg(socket, data, files); err != nil {
 		return
 	}
 
-	conn.Close()
+	for _, conn := range conns {
+		conn.Close()
+	}
 	return
 }
 
-// SendUnixFile sends a file descriptor embedded in file over the unix domain
-// socket.
-// On success the file is closed because the owner is now the process that
-// received the file descriptor.
-func SendUnixFile(socket *net.UnixConn, file *os.File) (err error) {
-	var fds = [1]int{int(file.Fd())}
-	var oob = syscall.UnixRights(fds[:]...)
+func sendUnixMsg(socket *net.UnixConn, data []byte, files []*os.File) (err error) {
+	if len(data) > unixMsgMaxLength {
+		return fmt.Errorf("netx: message of length %d is too large to send over a unix socket", len(data))
+	}
 
-	if _, _, err = socket.WriteMsgUnix(nil, oob, nil); err != nil {
-		return
+	length := uint32(len(data))
+	if len(files) != 0 {
+		length |= unixMsgHasFDs
 	}
 
-	file.Close()
+	buf := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(buf, length)
+	buf = append(buf, data...)
+
+	var oob []byte
+	if len(files) != 0 {
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+
+	_, _, err = socket.WriteMsgUnix(buf, oob, nil)
 	return
 }
 
 // RecvUnixConn receives a network connection from a unix domain socket.
 func RecvUnixConn(socket *net.UnixConn) (conn net.Conn, err error) {
-	var f *os.File
-	if f, err = RecvUnixFile(socket); err != nil {
+	_, fds, err := recvUnixMsg(socket)
+	if err != nil {
 		return
 	}
+
+	switch len(fds) {
+	case 1:
+	default:
+		closeFDs(fds)
+		return nil, fmt.Errorf("netx: expected 1 file descriptor but received %d", len(fds))
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "")
 	defer f.Close()
 	return net.FileConn(f)
 }
 
 // RecvUnixFile receives a file descriptor from a unix domain socket.
 func RecvUnixFile(socket *net.UnixConn) (file *os.File, err error) {
-	var oob = make([]byte, syscall.CmsgSpace(4))
-	var msg []syscall.SocketControlMessage
-	var fds []int
-
-	if _, _, _, _, err = socket.ReadMsgUnix(nil, oob); err != nil {
+	_, fds, err := recvUnixMsg(socket)
+	if err != nil {
 		return
 	}
 
-	if msg, err = syscall.ParseSocketControlMessage(oob); err != nil {
-		err = os.NewSyscallError("ParseSocketControlMessage", err)
+	switch len(fds) {
+	case 1:
+	default:
+		closeFDs(fds)
+		return nil, fmt.Errorf("netx: expected 1 file descriptor but received %d", len(fds))
+	}
+
+	file = os.NewFile(uintptr(fds[0]), "")
+	return
+}
+
+// RecvUnixMsg receives a framed message sent with SendUnixMsg from the unix
+// domain socket, returning the payload alongside the connections built from
+// any file descriptors that were passed with it.
+//
+// Any file descriptors received in excess of what the frame declared (for
+// instance a peer attaching rights to a message that claims to carry none)
+// are closed rather than handed back, so a misbehaving peer can't leak
+// descriptors into the process.
+func RecvUnixMsg(socket *net.UnixConn) (data []byte, conns []net.Conn, err error) {
+	data, fds, err := recvUnixMsg(socket)
+	if err != nil {
+		return
+	}
+	if len(fds) == 0 {
 		return
 	}
 
-	if len(msg) != 1 {
-		err = fmt.Errorf("invalid number of socket control messages, expected 1 but found %d", len(msg))
+	conns = make([]net.Conn, len(fds))
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "")
+		if conns[i], err = net.FileConn(f); err != nil {
+			f.Close()
+			closeFDs(fds[i+1:])
+			closeConns(conns[:i])
+			return nil, nil, err
+		}
+		f.Close()
+	}
+	return
+}
+
+func recvUnixMsg(socket *net.UnixConn) (data []byte, fds []int, err error) {
+	var header [4]byte
+	var oob []byte
+
+	if oob, err = recvUnixMsgFull(socket, header[:]); err != nil {
 		return
 	}
 
-	if fds, err = syscall.ParseUnixRights(&msg[0]); err != nil {
-		err = os.NewSyscallError("ParseUnixRights", err)
+	length := binary.BigEndian.Uint32(header[:])
+	hasFDs := length&unixMsgHasFDs != 0
+	length &^= unixMsgHasFDs
+
+	if length != 0 {
+		data = make([]byte, length)
+		if _, err = recvUnixMsgFull(socket, data); err != nil {
+			return
+		}
+	}
+
+	if fds, err = parseUnixRights(oob); err != nil {
 		return
 	}
 
-	if len(fds) != 1 {
-		for _, fd := range fds {
-			syscall.Close(fd)
+	if !hasFDs {
+		// The frame didn't declare any file descriptors, so anything the
+		// peer attached anyway is surplus and must not reach the caller.
+		closeFDs(fds)
+		fds = nil
+	}
+	return
+}
+
+// recvUnixMsgFull reads len(buf) bytes from socket, looping over ReadMsgUnix
+// as needed, and returns the out-of-band data collected along the way. Each
+// call reads exactly len(buf) bytes so that the control message attached to
+// the start of a frame can't be conflated with the next frame coalesced
+// right behind it.
+func recvUnixMsgFull(socket *net.UnixConn, buf []byte) (oob []byte, err error) {
+	oobBuf := make([]byte, syscall.CmsgSpace(4*unixMsgMaxFDs))
+	var n int
+
+	for n < len(buf) {
+		var rn, oobn int
+
+		if rn, oobn, _, _, err = socket.ReadMsgUnix(buf[n:], oobBuf); err != nil {
+			return
+		}
+		if rn == 0 {
+			err = io.ErrUnexpectedEOF
+			return
 		}
-		err = fmt.Errorf("too many file descriptors found in a single control message, %d were closed", len(fds))
+
+		n += rn
+		if oobn > 0 {
+			oob = append(oob, oobBuf[:oobn]...)
+		}
+	}
+
+	return
+}
+
+func parseUnixRights(oob []byte) (fds []int, err error) {
+	if len(oob) == 0 {
 		return
 	}
 
-	file = os.NewFile(uintptr(fds[0]), "")
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, os.NewSyscallError("ParseSocketControlMessage", err)
+	}
+
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			closeFDs(fds)
+			return nil, os.NewSyscallError("ParseUnixRights", err)
+		}
+		fds = append(fds, rights...)
+	}
+
 	return
-}
\ No newline at end of file
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+func closeConns(conns []net.Conn) {
+	for _, c := range conns {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
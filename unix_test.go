@@ -0,0 +1,198 @@
+package netx
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func unixSocketpair(t *testing.T) (a, b *net.UnixConn) {
+	t.Helper()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a = unixConnFromFD(t, fds[0])
+	b = unixConnFromFD(t, fds[1])
+	return
+}
+
+func unixConnFromFD(t *testing.T, fd int) *net.UnixConn {
+	t.Helper()
+
+	f := os.NewFile(uintptr(fd), "")
+	c, err := net.FileConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	u, ok := c.(*net.UnixConn)
+	if !ok {
+		t.Fatalf("expected *net.UnixConn, got %T", c)
+	}
+	return u
+}
+
+func TestSendRecvUnixMsg(t *testing.T) {
+	control1, control2 := unixSocketpair(t)
+	defer control1.Close()
+	defer control2.Close()
+
+	payload1, payload2 := unixSocketpair(t)
+	defer payload1.Close()
+
+	data := []byte("hello fd")
+	if err := SendUnixMsg(control1, data, payload2); err != nil {
+		t.Fatal(err)
+	}
+
+	gotData, conns, err := RecvUnixMsg(control2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotData, data) {
+		t.Fatalf("data = %q, want %q", gotData, data)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("got %d connections, want 1", len(conns))
+	}
+	defer conns[0].Close()
+
+	// The received conn must be the live other end of the payload pair.
+	msg := []byte("ping")
+	if _, err := payload1.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conns[0], buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("read %q from transferred conn, want %q", buf, msg)
+	}
+}
+
+func TestSendRecvUnixMsgNoFDs(t *testing.T) {
+	control1, control2 := unixSocketpair(t)
+	defer control1.Close()
+	defer control2.Close()
+
+	data := []byte("no fds here")
+	if err := SendUnixMsg(control1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	gotData, conns, err := RecvUnixMsg(control2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotData, data) {
+		t.Fatalf("data = %q, want %q", gotData, data)
+	}
+	if len(conns) != 0 {
+		t.Fatalf("got %d connections, want 0", len(conns))
+	}
+}
+
+func TestSendRecvUnixFile(t *testing.T) {
+	control1, control2 := unixSocketpair(t)
+	defer control1.Close()
+	defer control2.Close()
+
+	payload1, payload2 := unixSocketpair(t)
+	defer payload1.Close()
+
+	payload2File, err := payload2.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload2.Close()
+
+	if err := SendUnixFile(control1, payload2File); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := RecvUnixFile(control2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := net.FileConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := []byte("ping")
+	if _, err := payload1.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("read %q from transferred file, want %q", buf, msg)
+	}
+}
+
+// TestRecvUnixMsgClosesSurplusFDs verifies that file descriptors attached to
+// a message which doesn't declare any (the high bit of the frame header is
+// unset) are closed rather than handed back, so a misbehaving peer can't
+// use this to leak descriptors into the process.
+func TestRecvUnixMsgClosesSurplusFDs(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("cannot introspect open file descriptors on this platform")
+	}
+
+	control1, control2 := unixSocketpair(t)
+	defer control1.Close()
+	defer control2.Close()
+
+	before := openFDCount(t)
+
+	const iterations = 25
+	for i := 0; i < iterations; i++ {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oob := syscall.UnixRights(int(pr.Fd()))
+		header := make([]byte, 4) // high bit unset: claims to carry no fds
+		if _, _, err := control1.WriteMsgUnix(header, oob, nil); err != nil {
+			t.Fatal(err)
+		}
+		pr.Close()
+		pw.Close()
+
+		_, conns, err := RecvUnixMsg(control2)
+		if err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+		if len(conns) != 0 {
+			t.Fatalf("iteration %d: got %d connections, want 0", i, len(conns))
+		}
+	}
+
+	after := openFDCount(t)
+	if after > before {
+		t.Fatalf("leaked file descriptors: had %d open before, %d after %d iterations", before, after, iterations)
+	}
+}
+
+func openFDCount(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
@@ -0,0 +1,165 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// UnixFDAddr is the net.Addr implementation used for the "unixfd"
+// pseudo-network: the address of a connection or listener that was built
+// from a file descriptor inherited from a parent process (for example one
+// received over a socketpair with RecvUnixConn).
+//
+// The Name field is informational only, it has no effect on dialing or
+// listening and exists so a numeric fd can be given a human readable suffix
+// (e.g. "7/metrics") when logged.
+type UnixFDAddr struct {
+	FD   uintptr
+	Name string
+}
+
+// Network returns "unixfd".
+func (a UnixFDAddr) Network() string { return "unixfd" }
+
+func (a UnixFDAddr) String() string {
+	if a.Name == "" {
+		return strconv.FormatUint(uint64(a.FD), 10)
+	}
+	return strconv.FormatUint(uint64(a.FD), 10) + "/" + a.Name
+}
+
+func parseUnixFDAddr(address string) (addr UnixFDAddr, err error) {
+	fdString, name := address, ""
+
+	if i := strings.IndexByte(address, '/'); i >= 0 {
+		fdString, name = address[:i], address[i+1:]
+	}
+
+	fd, err := strconv.ParseUint(fdString, 10, 64)
+	if err != nil {
+		return UnixFDAddr{}, fmt.Errorf("netx: malformed unixfd address %q: %w", address, err)
+	}
+
+	return UnixFDAddr{FD: uintptr(fd), Name: name}, nil
+}
+
+// DialUnixFD returns a net.Conn wrapping fd, which must refer to a socket
+// (typically one end of a socketpair handed down by a supervisor process).
+//
+// This is the dialing half of the "unixfd" network: it lets a process
+// establish a connection from a file descriptor it inherited rather than by
+// calling connect(2) itself.
+func DialUnixFD(fd uintptr) (net.Conn, error) {
+	f := os.NewFile(fd, "unixfd")
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// errUnixFDListenerClosed is returned by a unixfd listener's Accept method
+// once the listener has been closed.
+var errUnixFDListenerClosed = errors.New("netx: unixfd listener closed")
+
+// ListenUnixFD returns a net.Listener wrapping fd, which must refer to one
+// end of a socketpair handed down by a supervisor process.
+//
+// Unlike a regular listener, a unixfd listener doesn't accept(2) new
+// connections: fd already *is* the accepted connection, so Accept returns it
+// exactly once, then blocks until the listener is closed. This matches the
+// common supervisor-hands-worker-a-socketpair handoff pattern, letting the
+// worker drive it with the same net.Listener based server loop it would use
+// for a real listening socket.
+func ListenUnixFD(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "unixfd")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &unixFDListener{
+		conn: conn,
+		addr: UnixFDAddr{FD: fd},
+		done: make(chan struct{}),
+	}, nil
+}
+
+type unixFDListener struct {
+	conn      net.Conn
+	addr      net.Addr
+	once      sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (l *unixFDListener) Accept() (conn net.Conn, err error) {
+	accepted := false
+
+	l.once.Do(func() {
+		conn, accepted = l.conn, true
+	})
+
+	if accepted {
+		return
+	}
+
+	<-l.done
+	return nil, &net.OpError{Op: "accept", Net: "unixfd", Addr: l.addr, Err: errUnixFDListenerClosed}
+}
+
+func (l *unixFDListener) Close() error {
+	l.once.Do(func() {
+		l.conn.Close()
+	})
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *unixFDListener) Addr() net.Addr { return l.addr }
+
+// NetworkResolver is implemented by types that know how to dial and listen
+// on a pseudo-network that net.Dial and net.Listen don't support natively.
+//
+// It lets netx dispatch on net.Addr.Network() the same way it would for any
+// built-in network like "tcp" or "unix".
+type NetworkResolver interface {
+	DialNetwork(ctx context.Context, address string) (net.Conn, error)
+	ListenNetwork(address string) (net.Listener, error)
+}
+
+// networks holds the NetworkResolver registered for each pseudo-network
+// name recognized by netx.
+var networks = map[string]NetworkResolver{
+	"unixfd": unixFDResolver{},
+}
+
+// RegisterNetwork makes resolver available under network, so that Tunnel's
+// default dialer (and any other netx code that consults networks) knows how
+// to dial and listen on it.
+func RegisterNetwork(network string, resolver NetworkResolver) {
+	networks[network] = resolver
+}
+
+type unixFDResolver struct{}
+
+func (unixFDResolver) DialNetwork(ctx context.Context, address string) (net.Conn, error) {
+	addr, err := parseUnixFDAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	return DialUnixFD(addr.FD)
+}
+
+func (unixFDResolver) ListenNetwork(address string) (net.Listener, error) {
+	addr, err := parseUnixFDAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	return ListenUnixFD(addr.FD)
+}
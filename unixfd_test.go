@@ -0,0 +1,199 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUnixFDAddr(t *testing.T) {
+	a := UnixFDAddr{FD: 7}
+	if a.Network() != "unixfd" {
+		t.Fatalf("Network() = %q, want %q", a.Network(), "unixfd")
+	}
+	if a.String() != "7" {
+		t.Fatalf("String() = %q, want %q", a.String(), "7")
+	}
+
+	a.Name = "metrics"
+	if a.String() != "7/metrics" {
+		t.Fatalf("String() = %q, want %q", a.String(), "7/metrics")
+	}
+}
+
+func TestParseUnixFDAddr(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    UnixFDAddr
+		wantErr bool
+	}{
+		{in: "7", want: UnixFDAddr{FD: 7}},
+		{in: "7/metrics", want: UnixFDAddr{FD: 7, Name: "metrics"}},
+		{in: "not-a-number", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseUnixFDAddr(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseUnixFDAddr(%q): expected an error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseUnixFDAddr(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseUnixFDAddr(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+// socketpairFDs returns two connected unix socket fds; the second is meant
+// to be handed off to DialUnixFD/ListenUnixFD, the first kept as the peer.
+func socketpairFDs(t *testing.T) (peer *net.UnixConn, fd uintptr) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unixConnFromFD(t, fds[0]), uintptr(fds[1])
+}
+
+func TestDialUnixFD(t *testing.T) {
+	peer, fd := socketpairFDs(t)
+	defer peer.Close()
+
+	conn, err := DialUnixFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := []byte("hi")
+	if _, err := peer.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("read %q, want %q", buf, msg)
+	}
+}
+
+func TestListenUnixFD(t *testing.T) {
+	peer, fd := socketpairFDs(t)
+	defer peer.Close()
+
+	ln, err := ListenUnixFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ln.Addr().Network() != "unixfd" {
+		t.Fatalf("Addr().Network() = %q, want %q", ln.Addr().Network(), "unixfd")
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := []byte("hi")
+	if _, err := peer.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("read %q, want %q", buf, msg)
+	}
+
+	// A second Accept should block until the listener is closed, then
+	// report an error rather than handing out the connection again.
+	done := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("second Accept returned early with %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ln.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error from Accept after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second Accept did not unblock after Close")
+	}
+}
+
+// TestUnixFDListenerCloseConcurrent is a regression test for a race where
+// concurrent Close calls could both observe l.done as open and both call
+// close(l.done), panicking.
+func TestUnixFDListenerCloseConcurrent(t *testing.T) {
+	peer, fd := socketpairFDs(t)
+	defer peer.Close()
+
+	ln, err := ListenUnixFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ln.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUnixFDNetworkResolver(t *testing.T) {
+	peer, fd := socketpairFDs(t)
+	defer peer.Close()
+
+	resolver, ok := networks["unixfd"]
+	if !ok {
+		t.Fatal(`networks["unixfd"] not registered`)
+	}
+
+	conn, err := resolver.DialNetwork(context.Background(), strconv.FormatUint(uint64(fd), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := []byte("hi")
+	if _, err := peer.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("read %q, want %q", buf, msg)
+	}
+}